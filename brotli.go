@@ -0,0 +1,76 @@
+// Copyright 2022 Klaus Post.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/bufbuild/connect-go"
+)
+
+func brComp(level Level, o Opts) (d func() connect.Decompressor, c func() connect.Compressor) {
+	wopts := brotli.WriterOptions{Quality: brotliQuality(level)}
+	if o.contains(OptSmallWindow) {
+		wopts.LGWin = 16
+	}
+
+	// Warm-pooled the same way as gzComp; see the note above it in
+	// compress.go.
+	getReader := poolOrDirect(o.poolSize(), func() interface{} {
+		return &brReader{}
+	})
+	getWriter := poolOrDirect(o.poolSize(), func() interface{} {
+		return brotli.NewWriterOptions(ioutil.Discard, wopts)
+	})
+	return func() connect.Decompressor {
+			return getReader().(*brReader)
+		}, func() connect.Compressor {
+			return getWriter().(*brotli.Writer)
+		}
+}
+
+// brotliQuality maps a Level to a Brotli quality, 0-11.
+func brotliQuality(level Level) int {
+	switch level {
+	case LevelFastest:
+		return 1
+	case LevelSmallest:
+		return 11
+	default:
+		return 5
+	}
+}
+
+// brReader adapts *brotli.Reader to connect.Decompressor. The brotli.Reader
+// type has no Reset method of its own, so Reset just replaces it with a
+// fresh one.
+type brReader struct {
+	r *brotli.Reader
+}
+
+func (r *brReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *brReader) Reset(reader io.Reader) error {
+	r.r = brotli.NewReader(reader)
+	return nil
+}
+
+func (r *brReader) Close() error {
+	return nil
+}
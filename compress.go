@@ -40,8 +40,89 @@ const (
 	// compression method.
 	// This is generally not recommended.
 	LevelSmallest
+
+	// LevelCustom indicates that the numeric settings in a Settings value
+	// passed to WithNewTuned or WithNewTunedE should be used instead of one
+	// of the presets above.
+	LevelCustom
 )
 
+// Settings carries the algorithm-specific numeric tuning consulted by
+// WithNewTuned and WithNewTunedE when Level is LevelCustom. Only the
+// fields relevant to the algorithm name passed to those constructors are
+// read; the rest are ignored.
+type Settings struct {
+	// GzipLevel is the gzip compression level, from gzip.BestSpeed (1) to
+	// gzip.BestCompression (9).
+	GzipLevel int
+
+	// ZstdLevel is the zstd encoder level.
+	ZstdLevel zstd.EncoderLevel
+
+	// ZstdWindowLog sets the zstd encoder window size to 1<<ZstdWindowLog
+	// bytes. If zero, a default based on OptSmallWindow is used.
+	ZstdWindowLog uint
+
+	// S2Mode selects the s2 writer compression mode.
+	S2Mode S2Mode
+
+	// S2BlockSize overrides the s2 writer block size, in bytes.
+	// If zero, the s2 writer's default block size is used, or 64KB when
+	// OptSmallWindow is set, whatever S2Mode is.
+	S2BlockSize int
+}
+
+// S2Mode selects an s2 writer compression mode for Settings.
+type S2Mode int
+
+const (
+	// S2ModeNone uses the default, fastest s2 compression mode.
+	S2ModeNone S2Mode = iota
+
+	// S2ModeBetter trades some speed for a better compression ratio.
+	S2ModeBetter
+
+	// S2ModeBest uses the strongest and slowest s2 compression mode.
+	S2ModeBest
+)
+
+// validate reports whether s is in range for the algorithm name.
+func (s Settings) validate(name string) error {
+	switch name {
+	case Gzip:
+		if s.GzipLevel < gzip.BestSpeed || s.GzipLevel > gzip.BestCompression {
+			return fmt.Errorf("compress: GzipLevel %d out of range [%d,%d]", s.GzipLevel, gzip.BestSpeed, gzip.BestCompression)
+		}
+	case Zstandard:
+		if s.ZstdLevel < zstd.SpeedFastest || s.ZstdLevel > zstd.SpeedBestCompression {
+			return fmt.Errorf("compress: ZstdLevel %d out of range [%d,%d]", s.ZstdLevel, zstd.SpeedFastest, zstd.SpeedBestCompression)
+		}
+		if s.ZstdWindowLog != 0 && (s.ZstdWindowLog < 10 || s.ZstdWindowLog > 27) {
+			return fmt.Errorf("compress: ZstdWindowLog %d out of range [10,27]", s.ZstdWindowLog)
+		}
+	case Snappy:
+		// Snappy always forces s2.WriterSnappyCompat, which can only emit
+		// snappy-decodable blocks at the default mode; WriterBetterCompression
+		// and WriterBestCompression are not compatible with it.
+		if s.S2Mode != S2ModeNone {
+			return fmt.Errorf("compress: S2Mode %d not supported with Snappy, only S2ModeNone", s.S2Mode)
+		}
+		if s.S2BlockSize < 0 {
+			return fmt.Errorf("compress: S2BlockSize %d must not be negative", s.S2BlockSize)
+		}
+	case S2:
+		if s.S2Mode < S2ModeNone || s.S2Mode > S2ModeBest {
+			return fmt.Errorf("compress: S2Mode %d out of range", s.S2Mode)
+		}
+		if s.S2BlockSize < 0 {
+			return fmt.Errorf("compress: S2BlockSize %d must not be negative", s.S2BlockSize)
+		}
+	default:
+		return fmt.Errorf("compress: unknown compression name: %s", name)
+	}
+	return nil
+}
+
 const (
 	// Gzip provides faster compression methods than the standard library
 	// built-in to go-connect.
@@ -65,10 +146,16 @@ const (
 	// Expected performance is ~750MB/s on JSON streams.
 	// Size ~2% bigger than gzip on JSON stream.
 	S2 = "s2"
+
+	// Brotli uses the Brotli compression format, which many browsers and
+	// gRPC-Web clients prefer over gzip. It is generally slower than the
+	// other codecs in this package at a comparable level, but can give
+	// better compression, particularly at LevelSmallest.
+	Brotli = "br"
 )
 
 // Opts provides options
-type Opts uint32
+type Opts uint64
 
 func (o Opts) contains(x Opts) bool {
 	return o&x == x
@@ -96,19 +183,85 @@ const (
 
 	// internal snappy option
 	optSnappy
+
+	// OptSkipIncompressible runs a cheap entropy estimate on the start of
+	// each frame and stores it uncompressed instead of running it through
+	// the real codec if compression isn't expected to help. See
+	// WithSkipIncompressible.
+	OptSkipIncompressible
 )
 
+// The low byte of Opts holds flag bits (above); bits 8-31 hold the value
+// set through WithMinSize, and bits 32-47 hold the value set through
+// WithPool, packed into the same Opts that flags and WithNew's variadic
+// options already use. Bits 48-63 are currently unused.
+const (
+	minSizeShift = 8
+	minSizeBits  = 24
+	minSizeMask  = 1<<minSizeBits - 1
+
+	poolSizeShift = 32
+	poolSizeBits  = 16
+	poolSizeMask  = 1<<poolSizeBits - 1
+)
+
+// WithMinSize sets the minimum frame size, in bytes, below which a frame is
+// stored uncompressed rather than run through the real codec; see
+// wrapMinSize. If unset, or min <= 0, no minimum is applied. min is
+// clamped to what fits in minSizeBits, 16MB-1, which is far past any
+// sensible threshold for this option.
+func WithMinSize(min int) Opts {
+	if min < 0 {
+		min = 0
+	} else if min > minSizeMask {
+		min = minSizeMask
+	}
+	return Opts(min) << minSizeShift
+}
+
+// minSize extracts the value set by WithMinSize, or 0 if unset.
+func (o Opts) minSize() int {
+	return int(o>>minSizeShift) & minSizeMask
+}
+
+// WithPool sets the number of warm encoders/decoders to pre-construct for a
+// compression method, so the first size concurrent streams beyond what
+// connect-go's own pool already has warm don't pay full construction cost
+// on the hot path; see newWarmPool. If size <= 0, defaultPoolSize is used.
+// size is clamped to what fits in poolSizeBits. Without WithPool, no warm
+// pool is built.
+func WithPool(size int) Opts {
+	if size <= 0 {
+		size = defaultPoolSize
+	} else if size > poolSizeMask {
+		size = poolSizeMask
+	}
+	return Opts(size) << poolSizeShift
+}
+
+// poolSize extracts the value set by WithPool, or 0 if WithPool was never
+// used, meaning no warm pool is built at all.
+func (o Opts) poolSize() int {
+	return int(o>>poolSizeShift) & poolSizeMask
+}
+
+// WithSkipIncompressible is a convenience for OR-ing OptSkipIncompressible
+// into a WithNew/WithAll options list.
+func WithSkipIncompressible() Opts {
+	return OptSkipIncompressible
+}
+
 type compressorOption struct {
 	connect.ClientOption
 	connect.HandlerOption
 }
 
 // WithAll returns the client and handler option for all compression methods.
-// Order of preference is S2, Snappy, Zstandard, Gzip.
+// Order of preference is S2, Snappy, Zstandard, Gzip, Brotli.
 func WithAll(level Level, options ...Opts) connect.Option {
 	var opts []connect.Option
 
-	for _, name := range []string{Gzip, Zstandard, Snappy, S2} {
+	for _, name := range []string{Brotli, Gzip, Zstandard, Snappy, S2} {
 		opts = append(opts, WithNew(name, level, options...))
 	}
 	return connect.WithOptions(opts...)
@@ -125,46 +278,122 @@ func WithNew(name string, level Level, options ...Opts) connect.Option {
 	var c func() connect.Compressor
 	switch name {
 	case Gzip:
-		d, c = gzComp(level, o)
+		d, c = gzComp(level, o, Settings{})
 	case Zstandard:
-		d, c = zstdComp(level, o)
+		d, c = zstdComp(level, o, Settings{})
 	case Snappy:
 		o |= optSnappy
-		d, c = s2Comp(level, o)
+		d, c = s2Comp(level, o, Settings{})
 	case S2:
-		d, c = s2Comp(level, o)
+		d, c = s2Comp(level, o, Settings{})
+	case Brotli:
+		d, c = brComp(level, o)
 	default:
 		panic(fmt.Errorf("unknown compression name: %s", name))
 	}
+	d, c = wrapMinSize(o, d, c)
+	wireName := minSizeName(name, o)
 	return &compressorOption{
-		ClientOption:  connect.WithAcceptCompression(name, d, c),
-		HandlerOption: connect.WithCompression(name, d, c),
+		ClientOption:  connect.WithAcceptCompression(wireName, d, c),
+		HandlerOption: connect.WithCompression(wireName, d, c),
 	}
 }
 
-func gzComp(level Level, o Opts) (d func() connect.Decompressor, c func() connect.Compressor) {
+// WithNewTuned is like WithNew, but uses the numeric fields in settings
+// instead of one of the Level presets. It panics if settings is out of
+// range for name; use WithNewTunedE to get an error instead.
+func WithNewTuned(name string, settings Settings, options ...Opts) connect.Option {
+	opt, err := WithNewTunedE(name, settings, options...)
+	if err != nil {
+		panic(err)
+	}
+	return opt
+}
+
+// WithNewTunedE is like WithNewTuned, but returns an error instead of
+// panicking when settings is out of range for name.
+func WithNewTunedE(name string, settings Settings, options ...Opts) (connect.Option, error) {
+	if err := settings.validate(name); err != nil {
+		return nil, err
+	}
+	var o Opts
+	for _, opt := range options {
+		o = o | opt
+	}
+	var d func() connect.Decompressor
+	var c func() connect.Compressor
+	switch name {
+	case Gzip:
+		d, c = gzComp(LevelCustom, o, settings)
+	case Zstandard:
+		d, c = zstdComp(LevelCustom, o, settings)
+	case Snappy:
+		o |= optSnappy
+		d, c = s2Comp(LevelCustom, o, settings)
+	case S2:
+		d, c = s2Comp(LevelCustom, o, settings)
+	default:
+		return nil, fmt.Errorf("compress: unknown compression name: %s", name)
+	}
+	d, c = wrapMinSize(o, d, c)
+	wireName := minSizeName(name, o)
+	return &compressorOption{
+		ClientOption:  connect.WithAcceptCompression(wireName, d, c),
+		HandlerOption: connect.WithCompression(wireName, d, c),
+	}, nil
+}
+
+// gzComp, zstdComp and s2Comp each run their constructors through
+// poolOrDirect, sized by WithPool, to warm-pool the encoders/decoders they
+// build (see warmPool's doc comment for why that's safe to layer on top of
+// connect-go's own per-wrapper pooling): connect-go keeps each
+// Compressor/Decompressor it gets from these factories in its own
+// sync.Pool and reuses it across frames via Reset, calling the factory
+// again only on a pool miss, so warm-pooling here only ever supplies a
+// fresh, not-yet-issued instance for that miss, never one connect-go still
+// holds a reference to. Without WithPool, poolOrDirect falls through to
+// building a fresh instance on every miss, same as before this existed.
+func gzComp(level Level, o Opts, settings Settings) (d func() connect.Decompressor, c func() connect.Compressor) {
+	newReader := func() interface{} {
+		return &gzip.Reader{}
+	}
+	newWriter := func() interface{} {
+		if o.contains(OptStatelessGzip) {
+			gz, _ := gzip.NewWriterLevel(ioutil.Discard, gzip.StatelessCompression)
+			return gz
+		}
+		switch level {
+		case LevelFastest:
+			gz, _ := gzip.NewWriterLevel(ioutil.Discard, 1)
+			return gz
+		case LevelSmallest:
+			gz, _ := gzip.NewWriterLevel(ioutil.Discard, 9)
+			return gz
+		case LevelCustom:
+			gz, _ := gzip.NewWriterLevel(ioutil.Discard, settings.GzipLevel)
+			return gz
+		}
+		return gzip.NewWriter(ioutil.Discard)
+	}
+	getReader := poolOrDirect(o.poolSize(), newReader)
+	getWriter := poolOrDirect(o.poolSize(), newWriter)
 	return func() connect.Decompressor {
-			return &gzip.Reader{}
+			return getReader().(*gzip.Reader)
 		}, func() connect.Compressor {
-			if o.contains(OptStatelessGzip) {
-				gz, _ := gzip.NewWriterLevel(ioutil.Discard, gzip.StatelessCompression)
-				return gz
-			}
-			switch level {
-			case LevelFastest:
-				gz, _ := gzip.NewWriterLevel(ioutil.Discard, 1)
-				return gz
-			case LevelSmallest:
-				gz, _ := gzip.NewWriterLevel(ioutil.Discard, 9)
-				return gz
-			}
-			return gzip.NewWriter(ioutil.Discard)
+			return getWriter().(*gzip.Writer)
 		}
 }
 
-func zstdComp(level Level, o Opts) (d func() connect.Decompressor, c func() connect.Compressor) {
-	copts := []zstd.EOption{zstd.WithLowerEncoderMem(true)}
-	dopts := []zstd.DOption{zstd.WithDecoderLowmem(true), zstd.WithDecoderConcurrency(1)}
+func zstdComp(level Level, o Opts, settings Settings) (d func() connect.Decompressor, c func() connect.Compressor) {
+	copts, dopts := zstdOpts(level, o, settings)
+	return zstdConstruct(o, copts, dopts)
+}
+
+// zstdOpts builds the zstd encoder/decoder options shared by zstdComp and
+// the dictionary-backed constructor in dict.go.
+func zstdOpts(level Level, o Opts, settings Settings) (copts []zstd.EOption, dopts []zstd.DOption) {
+	copts = []zstd.EOption{zstd.WithLowerEncoderMem(true)}
+	dopts = []zstd.DOption{zstd.WithDecoderLowmem(true), zstd.WithDecoderConcurrency(1)}
 	if o.contains(OptSmallWindow) {
 		dopts = append(dopts, zstd.WithDecoderMaxWindow(64<<10))
 	}
@@ -198,13 +427,36 @@ func zstdComp(level Level, o Opts) (d func() connect.Decompressor, c func() conn
 		} else {
 			copts = append(copts, zstd.WithWindowSize(4<<20))
 		}
+	case LevelCustom:
+		copts = append(copts, zstd.WithEncoderLevel(settings.ZstdLevel))
+		switch {
+		case settings.ZstdWindowLog != 0:
+			copts = append(copts, zstd.WithWindowSize(1<<settings.ZstdWindowLog))
+		case o.contains(OptSmallWindow):
+			copts = append(copts, zstd.WithWindowSize(64<<10))
+		default:
+			copts = append(copts, zstd.WithWindowSize(1<<20))
+		}
 	}
+	return copts, dopts
+}
+
+// zstdConstruct builds the decompressor/compressor factories for a set of
+// zstd options, warm-pooled per the note above gzComp. It is shared by
+// zstdComp and the dictionary-backed constructor in dict.go.
+func zstdConstruct(o Opts, copts []zstd.EOption, dopts []zstd.DOption) (d func() connect.Decompressor, c func() connect.Compressor) {
+	getReader := poolOrDirect(o.poolSize(), func() interface{} {
+		zs, _ := zstd.NewReader(nil, dopts...)
+		return &zstdWrapper{ReadCloser: zs.IOReadCloser(), dec: zs}
+	})
+	getWriter := poolOrDirect(o.poolSize(), func() interface{} {
+		zs, _ := zstd.NewWriter(nil, copts...)
+		return zs
+	})
 	return func() connect.Decompressor {
-			zs, _ := zstd.NewReader(nil, dopts...)
-			return &zstdWrapper{ReadCloser: zs.IOReadCloser(), dec: zs}
+			return getReader().(*zstdWrapper)
 		}, func() connect.Compressor {
-			zs, _ := zstd.NewWriter(nil, copts...)
-			return zs
+			return getWriter().(*zstd.Encoder)
 		}
 }
 
@@ -217,7 +469,7 @@ func (z *zstdWrapper) Reset(reader io.Reader) error {
 	return z.dec.Reset(reader)
 }
 
-func s2Comp(level Level, o Opts) (d func() connect.Decompressor, c func() connect.Compressor) {
+func s2Comp(level Level, o Opts, settings Settings) (d func() connect.Decompressor, c func() connect.Compressor) {
 	var wopts []s2.WriterOption
 	var ropts []s2.ReaderOption
 	if o.contains(optSnappy) {
@@ -241,13 +493,28 @@ func s2Comp(level Level, o Opts) (d func() connect.Decompressor, c func() connec
 		if !o.contains(OptSmallWindow) && !o.contains(optSnappy) {
 			wopts = append(wopts, s2.WriterBlockSize(4<<20))
 		}
+	case LevelCustom:
+		switch settings.S2Mode {
+		case S2ModeBetter:
+			wopts = append(wopts, s2.WriterBetterCompression())
+		case S2ModeBest:
+			wopts = append(wopts, s2.WriterBestCompression())
+		}
+		if settings.S2BlockSize > 0 {
+			wopts = append(wopts, s2.WriterBlockSize(settings.S2BlockSize))
+		}
 	}
 
+	getReader := poolOrDirect(o.poolSize(), func() interface{} {
+		return &s2rWrapper{dec: s2.NewReader(nil, ropts...)}
+	})
+	getWriter := poolOrDirect(o.poolSize(), func() interface{} {
+		return s2.NewWriter(nil, wopts...)
+	})
 	return func() connect.Decompressor {
-			dec := s2.NewReader(nil, ropts...)
-			return &s2rWrapper{dec: dec}
+			return getReader().(*s2rWrapper)
 		}, func() connect.Compressor {
-			return s2.NewWriter(nil, wopts...)
+			return getWriter().(*s2.Writer)
 		}
 }
 
@@ -0,0 +1,129 @@
+// Copyright 2022 Klaus Post.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/klauspost/compress/zstd"
+)
+
+// WithZstdDict returns client and handler options for Zstandard compression
+// using a pre-shared dictionary, built with TrainZstdDict. Dictionaries pay
+// off most on the small, repetitive proto messages typical of RPC payloads,
+// where a shared dictionary can cut size far more than plain Zstandard.
+//
+// The codec is registered under its own accept-encoding name derived from
+// dict, distinct from Zstandard, so a peer that was not configured with
+// this dictionary simply never negotiates it and falls back to whatever
+// other codec is shared instead of failing. Both peers must still load the
+// exact same dictionary bytes: TrainZstdDict builds a raw-content
+// dictionary, which carries none of the dictionary-ID metadata a
+// COVER-trained one would, so a mismatched dictionary is not detected by
+// zstd at all and silently produces garbage instead of an error.
+//
+// It panics if dict is unusable by the zstd encoder/decoder; use
+// WithZstdDictE to get an error instead.
+func WithZstdDict(dict []byte, level Level, options ...Opts) connect.Option {
+	opt, err := WithZstdDictE(dict, level, options...)
+	if err != nil {
+		panic(err)
+	}
+	return opt
+}
+
+// WithZstdDictE is like WithZstdDict, but returns an error instead of
+// panicking if dict is unusable by the zstd encoder/decoder.
+func WithZstdDictE(dict []byte, level Level, options ...Opts) (connect.Option, error) {
+	return withZstdDictNamed(zstdDictName(dict), dict, level, options...)
+}
+
+// withZstdDictNamed is split out of WithZstdDict so tests can pin the
+// accept-encoding name instead of depending on the hash of dict.
+func withZstdDictNamed(name string, dict []byte, level Level, options ...Opts) (connect.Option, error) {
+	var o Opts
+	for _, opt := range options {
+		o = o | opt
+	}
+	copts, dopts := zstdOpts(level, o, Settings{})
+	copts = append(copts, zstd.WithEncoderDict(dict))
+	dopts = append(dopts, zstd.WithDecoderDicts(dict))
+
+	// zstdConstruct's factories discard zstd.NewWriter/NewReader's errors,
+	// which is fine when copts/dopts come from this package and never fail;
+	// dict is user-supplied, so validate it here, once, at setup time,
+	// rather than have a bad dictionary surface as a nil-encoder panic on
+	// the first real RPC.
+	zw, err := zstd.NewWriter(nil, copts...)
+	if err != nil {
+		return nil, fmt.Errorf("compress: dict rejected by zstd encoder: %w", err)
+	}
+	zw.Close()
+	zr, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		return nil, fmt.Errorf("compress: dict rejected by zstd decoder: %w", err)
+	}
+	zr.Close()
+
+	d, c := zstdConstruct(o, copts, dopts)
+	return &compressorOption{
+		ClientOption:  connect.WithAcceptCompression(name, d, c),
+		HandlerOption: connect.WithCompression(name, d, c),
+	}, nil
+}
+
+// zstdDictName derives a stable accept-encoding name from dict, so the same
+// dictionary bytes always produce the same name across processes without
+// either peer needing to agree on one out of band.
+func zstdDictName(dict []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(dict)
+	return fmt.Sprintf("zstd-dict-%x", h.Sum64())
+}
+
+// TrainZstdDict builds a Zstandard dictionary from samples, for use with
+// WithZstdDict. samples should be a representative set of the messages
+// that will be compressed; a few hundred to a few thousand samples of a
+// few hundred bytes to a few KB each is typical for proto RPC payloads.
+//
+// This builds a raw-content dictionary: samples are concatenated in the
+// order given, and only the last dictSize bytes are kept. Zstd treats any
+// byte string as a valid dictionary this way, so unlike the reference zstd
+// CLI's --train mode (which runs the COVER algorithm to synthesize an
+// optimal set of substrings) this does no entropy analysis. It still
+// captures most of the win on payloads that share a lot of literal
+// structure, such as repeated proto field tags and enum string values.
+func TrainZstdDict(samples [][]byte, dictSize int) ([]byte, error) {
+	if dictSize <= 0 {
+		return nil, fmt.Errorf("compress: dictSize must be positive")
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("compress: no samples given")
+	}
+
+	var dict []byte
+	for _, s := range samples {
+		dict = append(dict, s...)
+	}
+	if len(dict) > dictSize {
+		// Keep the tail: zstd's window looks backwards from the start of
+		// the real data, so bytes closest to the end of the dictionary are
+		// the cheapest for the encoder to reference.
+		dict = dict[len(dict)-dictSize:]
+	}
+	return dict, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 Klaus Post.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/klauspost/compress/s2"
+)
+
+// Frame marker bytes written by minSizeCompressor and read by
+// minSizeDecompressor. They replace nothing in the wire format of the real
+// codec; they're a one-byte prefix this package adds in front of it.
+const (
+	minSizeMarkerStored byte = 0
+	minSizeMarkerReal   byte = 1
+)
+
+// entropySampleSize is how much of a frame minSizeCompressor looks at to
+// decide whether it's worth compressing, when OptSkipIncompressible is set.
+const entropySampleSize = 4 << 10
+
+// wrapMinSize wraps d and c with WithMinSize/WithSkipIncompressible gating
+// if o asks for either; otherwise it returns them unchanged.
+func wrapMinSize(o Opts, d func() connect.Decompressor, c func() connect.Compressor) (func() connect.Decompressor, func() connect.Compressor) {
+	min := o.minSize()
+	skip := o.contains(OptSkipIncompressible)
+	if min == 0 && !skip {
+		return d, c
+	}
+	return func() connect.Decompressor {
+			return &minSizeDecompressor{newReal: d}
+		}, func() connect.Compressor {
+			return &minSizeCompressor{newReal: c, minSize: min, skipIncompressible: skip}
+		}
+}
+
+// minSizeName returns the accept-encoding name to register d/c under once
+// wrapMinSize has wrapped them for o. A gated frame carries an extra marker
+// byte that a plain gzip/zstd/s2 decoder doesn't expect, so it must not be
+// negotiated under the standard name: a peer without this option would
+// decode the marker byte as the start of the real stream and fail, or
+// worse, silently produce garbage. Registering it under a distinct name
+// instead means a peer that doesn't know about it simply never negotiates
+// it and falls back to another shared codec.
+func minSizeName(name string, o Opts) string {
+	if o.minSize() == 0 && !o.contains(OptSkipIncompressible) {
+		return name
+	}
+	return name + "-min"
+}
+
+// minSizeCompressor buffers an entire frame, then at Close either emits it
+// with a "stored" marker byte or runs it through the real compressor,
+// based on its size and (if enabled) a cheap compressibility estimate.
+// This trades buffering the whole frame in memory for skipping compression
+// overhead entirely on frames where it isn't worth it.
+type minSizeCompressor struct {
+	newReal            func() connect.Compressor
+	minSize            int
+	skipIncompressible bool
+
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (c *minSizeCompressor) Reset(w io.Writer) {
+	c.w = w
+	c.buf.Reset()
+}
+
+func (c *minSizeCompressor) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func (c *minSizeCompressor) Close() error {
+	data := c.buf.Bytes()
+	if len(data) < c.minSize || (c.skipIncompressible && looksIncompressible(data)) {
+		if _, err := c.w.Write([]byte{minSizeMarkerStored}); err != nil {
+			return err
+		}
+		_, err := c.w.Write(data)
+		return err
+	}
+
+	if _, err := c.w.Write([]byte{minSizeMarkerReal}); err != nil {
+		return err
+	}
+	real := c.newReal()
+	real.Reset(c.w)
+	if _, err := real.Write(data); err != nil {
+		return err
+	}
+	return real.Close()
+}
+
+// looksIncompressible estimates whether data is worth running through a
+// real compressor, using s2's block size estimator on a leading sample so
+// large already-compressed payloads (images, pre-gzipped blobs) don't pay
+// for a full compression pass that won't shrink them.
+func looksIncompressible(data []byte) bool {
+	sample := data
+	if len(sample) > entropySampleSize {
+		sample = sample[:entropySampleSize]
+	}
+	return s2.EstimateBlockSize(sample) < 0
+}
+
+// minSizeDecompressor reads the marker byte minSizeCompressor wrote and
+// either passes the rest of the frame through unchanged or delegates to a
+// real decompressor.
+type minSizeDecompressor struct {
+	newReal func() connect.Decompressor
+
+	r      io.Reader
+	real   connect.Decompressor
+	marker byte
+	read   bool
+}
+
+func (d *minSizeDecompressor) Reset(r io.Reader) error {
+	d.r = r
+	d.real = nil
+	d.read = false
+	return nil
+}
+
+func (d *minSizeDecompressor) readMarker() error {
+	if d.read {
+		return nil
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return err
+	}
+	d.read = true
+	d.marker = b[0]
+	if d.marker == minSizeMarkerReal {
+		d.real = d.newReal()
+		if err := d.real.Reset(d.r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *minSizeDecompressor) Read(p []byte) (int, error) {
+	if err := d.readMarker(); err != nil {
+		return 0, err
+	}
+	if d.marker == minSizeMarkerStored {
+		return d.r.Read(p)
+	}
+	return d.real.Read(p)
+}
+
+func (d *minSizeDecompressor) Close() error {
+	if d.real != nil {
+		return d.real.Close()
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 Klaus Post.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestMinSizeRoundTrip exercises both paths a minSizeCompressor/
+// minSizeDecompressor pair can take: a frame small enough to be stored
+// uncompressed, and one large enough to go through the real codec.
+func TestMinSizeRoundTrip(t *testing.T) {
+	d, c := gzComp(LevelBalanced, 0, Settings{})
+	wrappedD, wrappedC := wrapMinSize(WithMinSize(64), d, c)
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"below min size, stored", bytes.Repeat([]byte("a"), 8)},
+		{"above min size, compressed", bytes.Repeat([]byte("hello world "), 100)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			comp := wrappedC()
+			comp.Reset(&buf)
+			if _, err := comp.Write(tc.data); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := comp.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			decomp := wrappedD()
+			if err := decomp.Reset(&buf); err != nil {
+				t.Fatalf("Reset: %v", err)
+			}
+			got, err := io.ReadAll(decomp)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(tc.data))
+			}
+		})
+	}
+}
+
+// TestMinSizeName checks that gating changes the accept-encoding name a
+// codec is registered under, so gated frames (which carry an extra marker
+// byte) are never mistaken for standard-format frames by a peer that
+// doesn't share the option.
+func TestMinSizeName(t *testing.T) {
+	if got := minSizeName(Gzip, 0); got != Gzip {
+		t.Errorf("ungated name = %q, want %q", got, Gzip)
+	}
+	if got := minSizeName(Gzip, WithMinSize(64)); got == Gzip {
+		t.Errorf("gated name must differ from %q, got %q", Gzip, got)
+	}
+	if got := minSizeName(Gzip, WithSkipIncompressible()); got == Gzip {
+		t.Errorf("gated name must differ from %q, got %q", Gzip, got)
+	}
+}
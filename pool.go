@@ -0,0 +1,71 @@
+// Copyright 2022 Klaus Post.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+// defaultPoolSize is the number of warm encoders/decoders kept ready per
+// algorithm when WithPool is called with size <= 0.
+const defaultPoolSize = 16
+
+// warmPool hands out pre-constructed objects to cut the cost of a
+// connect-go pool miss on the hot path. Unlike a sync.Pool fed by Close,
+// nothing is ever put back into a warmPool: an object taken from ch is
+// never seen by the pool again, so there is no way for the same object to
+// end up aliased between two concurrent streams the way returning a
+// still-referenced encoder/decoder to a pool on Close did. A background
+// goroutine keeps ch topped up by building replacements in advance,
+// off the hot path, throttled naturally by ch's capacity.
+type warmPool struct {
+	ch  chan interface{}
+	new func() interface{}
+}
+
+// newWarmPool starts a warmPool of the given size. new is called to
+// construct each replacement, both up front and by the background
+// goroutine as the pool is drained.
+func newWarmPool(size int, new func() interface{}) *warmPool {
+	p := &warmPool{ch: make(chan interface{}, size), new: new}
+	for i := 0; i < size; i++ {
+		p.ch <- new()
+	}
+	go p.refill()
+	return p
+}
+
+func (p *warmPool) refill() {
+	for {
+		p.ch <- p.new()
+	}
+}
+
+// get returns a warm object if one is ready, or constructs one immediately
+// if the pool hasn't caught up.
+func (p *warmPool) get() interface{} {
+	select {
+	case v := <-p.ch:
+		return v
+	default:
+		return p.new()
+	}
+}
+
+// poolOrDirect returns a factory function backed by a warmPool of size
+// size, or, if size is 0 (WithPool was never used), just new itself, with
+// no pool and no background goroutine.
+func poolOrDirect(size int, new func() interface{}) func() interface{} {
+	if size <= 0 {
+		return new
+	}
+	return newWarmPool(size, new).get
+}